@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const wsChallengeTTL = 30 * time.Second
+
+var authToken string
+
+// setupAuthToken resolves the token every /api/* request must present: the
+// --auth-token flag if one was given, otherwise whatever is already saved at
+// store-dir/token, otherwise a freshly generated one persisted there.
+func setupAuthToken(storeDir, flagToken string) (string, error) {
+	if flagToken != "" {
+		return flagToken, nil
+	}
+
+	path := filepath.Join(storeDir, "token")
+	if b, err := os.ReadFile(path); err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	token := randomID() + randomID()
+	if err := os.MkdirAll(storeDir, 0o700); err != nil {
+		return "", fmt.Errorf("creating store dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(token), 0o600); err != nil {
+		return "", fmt.Errorf("saving token: %w", err)
+	}
+
+	return token, nil
+}
+
+// AuthMiddleware requires every request to present authToken, either as an
+// `Authorization: Bearer <token>` header or a `?token=` query parameter.
+// /api/events may instead present a short-lived challenge from
+// /api/ws-challenge, since browsers can't set custom headers on WebSocket
+// upgrades.
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/events" && validateWSChallenge(r.URL.Query().Get("challenge")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(authToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+var wsChallenges = struct {
+	mu sync.Mutex
+	m  map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// handleWSChallenge issues a single-use nonce the browser can put in the
+// /api/events URL instead of the long-lived auth token.
+func handleWSChallenge(w http.ResponseWriter, r *http.Request) {
+	challenge := randomID()
+
+	wsChallenges.mu.Lock()
+	wsChallenges.m[challenge] = time.Now().Add(wsChallengeTTL)
+	wsChallenges.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"challenge": challenge})
+}
+
+func validateWSChallenge(challenge string) bool {
+	if challenge == "" {
+		return false
+	}
+
+	wsChallenges.mu.Lock()
+	defer wsChallenges.mu.Unlock()
+
+	expires, ok := wsChallenges.m[challenge]
+	delete(wsChallenges.m, challenge) // single use, valid or not
+	return ok && time.Now().Before(expires)
+}
+
+// checkBindAddr refuses to listen on a non-loopback address unless the
+// caller opted in with --bind-public and auth is actually enabled.
+func checkBindAddr(addr string, bindPublic bool) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	if isLoopbackHost(host) {
+		return nil
+	}
+	if !bindPublic {
+		return fmt.Errorf("refusing to bind non-loopback address %q without --bind-public", addr)
+	}
+	if authToken == "" {
+		return fmt.Errorf("--bind-public requires auth to be enabled")
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// BridgeStateEvent is one of the stages a session's connection to WhatsApp
+// can be in, modeled on mautrix-whatsapp's BridgeState.
+type BridgeStateEvent string
+
+const (
+	StateStarting            BridgeStateEvent = "STARTING"
+	StateConnecting          BridgeStateEvent = "CONNECTING"
+	StateBackfilling         BridgeStateEvent = "BACKFILLING"
+	StateConnected           BridgeStateEvent = "CONNECTED"
+	StateTransientDisconnect BridgeStateEvent = "TRANSIENT_DISCONNECT"
+	StateBadCredentials      BridgeStateEvent = "BAD_CREDENTIALS"
+	StateLoggedOut           BridgeStateEvent = "LOGGED_OUT"
+)
+
+// BridgeState is the full connection-health record surfaced by /api/status
+// and streamed over /api/events.
+type BridgeState struct {
+	StateEvent BridgeStateEvent `json:"state_event"`
+	Reason     string           `json:"reason,omitempty"`
+	RemoteID   string           `json:"remote_id,omitempty"`
+	RemoteName string           `json:"remote_name,omitempty"`
+	LastSeen   int64            `json:"last_seen,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = 5 * time.Minute
+)
+
+// setBridgeState records a transition and notifies every subscriber (the
+// WebSocket stream among them).
+func (s *session) setBridgeState(bs BridgeState) {
+	bs.LastSeen = time.Now().Unix()
+
+	s.mu.Lock()
+	s.bridgeState = bs
+	subs := make([]chan BridgeState, 0, len(s.stateSubs))
+	for ch := range s.stateSubs {
+		subs = append(subs, ch)
+	}
+	s.mu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- bs:
+		default: // slow subscriber; they'll get the next transition
+		}
+	}
+}
+
+func (s *session) getBridgeState() BridgeState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bridgeState
+}
+
+// subscribeBridgeState registers a channel for state transitions; the
+// returned func unregisters it and must be called when the subscriber is done.
+func (s *session) subscribeBridgeState() (<-chan BridgeState, func()) {
+	ch := make(chan BridgeState, 8)
+
+	s.mu.Lock()
+	if s.stateSubs == nil {
+		s.stateSubs = make(map[chan BridgeState]struct{})
+	}
+	s.stateSubs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.stateSubs, ch)
+		s.mu.Unlock()
+		close(ch)
+	}
+}
+
+// registerStateHandlers wires whatsmeow connection events into the bridge
+// state machine, including auto-reconnect with jittered exponential backoff.
+func registerStateHandlers(s *session) {
+	s.setBridgeState(BridgeState{StateEvent: StateStarting})
+
+	s.client.AddEventHandler(func(evt any) {
+		switch e := evt.(type) {
+		case *events.Connected:
+			s.setState(loginConnected)
+			s.setBridgeState(BridgeState{StateEvent: StateConnected})
+		case *events.HistorySync:
+			s.setBridgeState(BridgeState{StateEvent: StateBackfilling})
+		case *events.Disconnected:
+			s.setStateUnlessLoggedOut(loginDisconnected)
+			s.setBridgeState(BridgeState{StateEvent: StateTransientDisconnect, Reason: "disconnected"})
+			triggerReconnect(s, "disconnected")
+		case *events.StreamReplaced:
+			s.setStateUnlessLoggedOut(loginDisconnected)
+			s.setBridgeState(BridgeState{StateEvent: StateTransientDisconnect, Reason: "stream_replaced"})
+			triggerReconnect(s, "stream_replaced")
+		case *events.ConnectFailure:
+			s.setStateUnlessLoggedOut(loginDisconnected)
+			s.setBridgeState(BridgeState{
+				StateEvent: StateTransientDisconnect,
+				Reason:     "connect_failure",
+				Error:      e.Reason.String(),
+			})
+			triggerReconnect(s, "connect_failure")
+		case *events.LoggedOut:
+			s.setState(loginLoggedOut)
+			if e.Reason == events.ConnectFailureLoggedOut {
+				// The user (or another device) explicitly logged this session out.
+				s.setBridgeState(BridgeState{
+					StateEvent: StateLoggedOut,
+					Reason:     "logged_out",
+					Error:      e.Reason.String(),
+				})
+			} else {
+				// Any other reason (banned, invalid session, device removed, ...)
+				// means the stored credentials themselves are no longer good, as
+				// opposed to a clean logout - the UI should prompt re-pairing
+				// rather than describing it as a simple logout.
+				s.setBridgeState(BridgeState{
+					StateEvent: StateBadCredentials,
+					Reason:     "bad_credentials",
+					Error:      e.Reason.String(),
+				})
+			}
+		}
+	})
+}
+
+// triggerReconnect starts reconnectWithBackoff unless one is already running
+// for this session; ConnectFailure can otherwise fire again while an earlier
+// attempt is still sleeping, stacking up concurrent Connect() calls.
+func triggerReconnect(s *session, reason string) {
+	if !s.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.reconnecting.Store(false)
+		reconnectWithBackoff(s, reason)
+	}()
+}
+
+// reconnectWithBackoff retries client.Connect with jittered exponential
+// backoff capped at reconnectMaxDelay, until it succeeds or the session is
+// logged out.
+func reconnectWithBackoff(s *session, reason string) {
+	delay := reconnectBaseDelay
+	for {
+		if s.getState() == loginLoggedOut {
+			return
+		}
+
+		jitter := time.Duration(rand.Float64() * float64(delay) * 0.3)
+		time.Sleep(delay + jitter)
+
+		if s.getState() == loginLoggedOut {
+			return
+		}
+
+		s.setBridgeState(BridgeState{StateEvent: StateConnecting, Reason: reason})
+		if err := s.client.Connect(context.Background()); err == nil {
+			return // *events.Connected will flip the state once the handshake finishes
+		} else {
+			fmt.Fprintf(os.Stderr, "Session %s reconnect failed: %v\n", s.jid, err)
+		}
+
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
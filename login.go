@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/CSCSoftware/wahoo/db"
+	"github.com/CSCSoftware/wahoo/wa"
+	"github.com/skip2/go-qrcode"
+)
+
+// handleLoginQR streams QR codes for a fresh pairing as whatsmeow's
+// GetQRChannel produces them, one Server-Sent Event per code, until the
+// browser's phone scans one (event "success") or the channel times out.
+func handleLoginQR(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	pendingID := "pending-" + randomID()
+	dir := sessions.dir(pendingID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	store, err := db.NewStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	wc, err := wa.NewClient(store, dir)
+	if err != nil {
+		store.Close()
+		os.RemoveAll(dir)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	qrChan, err := wc.GetQRChannel(r.Context())
+	if err != nil {
+		store.Close()
+		os.RemoveAll(dir)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s := &session{jid: pendingID, store: store, client: wc, state: loginQR}
+	registerMediaHandlers(s)
+	registerStateHandlers(s)
+	registerHistorySync(s)
+	go wc.Connect(r.Context())
+
+	// Unless pairing actually succeeds, always tear the pending session back
+	// down - including when qrChan just closes (e.g. the browser disconnects
+	// mid-scan) without ever reaching the "success" or "default" case below.
+	paired := false
+	defer func() {
+		if !paired {
+			wc.Disconnect()
+			store.Close()
+			os.RemoveAll(dir)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for evt := range qrChan {
+		switch evt.Event {
+		case "code":
+			png, err := qrcode.Encode(evt.Code, qrcode.Medium, 256)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: qr\ndata: %s\n\n", base64.StdEncoding.EncodeToString(png))
+			flusher.Flush()
+		case "success":
+			s.setState(loginPairSuccess)
+			jid := wc.StoreJID()
+			s.jid = jid
+			if err := os.Rename(dir, sessions.dir(jid)); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to move session dir for %s: %v\n", jid, err)
+			}
+			s.setState(loginConnected)
+			sessions.register(s)
+			paired = true
+			fmt.Fprintf(w, "event: success\ndata: {\"jid\":%q}\n\n", jid)
+			flusher.Flush()
+			return
+		default: // "timeout", "error", etc.
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", evt.Event)
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// handleLoginStatus reports the current pairing/connection state of a session.
+func handleLoginStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"state": loginDisconnected})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"session": s.jid,
+		"state":   s.getState(),
+	})
+}
+
+// handleLogout logs a session out of WhatsApp and forgets its stored credentials.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := s.client.Logout(r.Context()); err != nil {
+		fmt.Fprintf(os.Stderr, "Logout error for %s: %v\n", s.jid, err)
+	}
+	if err := sessions.remove(s.jid); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"success": true})
+}
+
+// handleSessions lists every paired session this instance is managing.
+func handleSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	list := sessions.list()
+	out := make([]map[string]any, 0, len(list))
+	for _, s := range list {
+		out = append(out, map[string]any{
+			"jid":   s.jid,
+			"state": s.getState(),
+		})
+	}
+
+	json.NewEncoder(w).Encode(out)
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
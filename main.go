@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"embed"
 	"encoding/json"
 	"flag"
@@ -16,61 +15,71 @@ import (
 	"time"
 
 	"github.com/CSCSoftware/wahoo/db"
-	"github.com/CSCSoftware/wahoo/wa"
 )
 
 //go:embed web/*
 var webFS embed.FS
 
-var (
-	store  *db.Store
-	client *wa.Client
-)
+var sessions *sessionManager
 
 func main() {
 	storeDir := flag.String("store-dir", "store", "Directory for SQLite databases")
 	addr := flag.String("addr", "localhost:8080", "HTTP server address")
 	noBrowser := flag.Bool("no-browser", false, "Don't open browser automatically")
+	authTokenFlag := flag.String("auth-token", "", "Token required on every /api/* request (generated and saved to store-dir/token if omitted)")
+	bindPublic := flag.Bool("bind-public", false, "Allow binding to a non-loopback address (requires auth)")
 	flag.Parse()
 
 	fmt.Println("wahoo-ui - WhatsApp Web Interface")
 	fmt.Printf("Store directory: %s\n", *storeDir)
 
-	// Open databases
 	var err error
-	store, err = db.NewStore(*storeDir)
+	authToken, err = setupAuthToken(*storeDir, *authTokenFlag)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open databases: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to set up auth token: %v\n", err)
 		os.Exit(1)
 	}
-	defer store.Close()
+	if *authTokenFlag == "" {
+		fmt.Printf("Auth token: %s\n", authToken)
+	}
 
-	// Create and connect WhatsApp client
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	if err := checkBindAddr(*addr, *bindPublic); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
-	client, err = wa.NewClient(store, *storeDir)
+	// Load and connect every previously paired session
+	sessions, err = newSessionManager(*storeDir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create WhatsApp client: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to load sessions: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Connect in background
-	go func() {
-		if err := client.Connect(ctx); err != nil {
-			fmt.Fprintf(os.Stderr, "WhatsApp connection error: %v\n", err)
-		}
-	}()
+	if len(sessions.list()) == 0 {
+		fmt.Println("No paired sessions yet; visit /api/login/qr to pair one")
+	}
 
 	// Setup HTTP server
 	mux := http.NewServeMux()
 
-	// API routes
-	mux.HandleFunc("/api/chats", handleChats)
-	mux.HandleFunc("/api/messages", handleMessages)
-	mux.HandleFunc("/api/send", handleSend)
-	mux.HandleFunc("/api/contacts", handleContacts)
-	mux.HandleFunc("/api/status", handleStatus)
+	// API routes, all behind AuthMiddleware
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("/api/chats", handleChats)
+	apiMux.HandleFunc("/api/messages", handleMessages)
+	apiMux.HandleFunc("/api/send", handleSend)
+	apiMux.HandleFunc("/api/send/media", handleSendMedia)
+	apiMux.HandleFunc("/api/media", handleMedia)
+	apiMux.HandleFunc("/api/media/preview", handleMediaPreview)
+	apiMux.HandleFunc("/api/contacts", handleContacts)
+	apiMux.HandleFunc("/api/status", handleStatus)
+	apiMux.HandleFunc("/api/events", handleEvents)
+	apiMux.HandleFunc("/api/ws-challenge", handleWSChallenge)
+	apiMux.HandleFunc("/api/login/qr", handleLoginQR)
+	apiMux.HandleFunc("/api/login/status", handleLoginStatus)
+	apiMux.HandleFunc("/api/logout", handleLogout)
+	apiMux.HandleFunc("/api/sessions", handleSessions)
+	apiMux.HandleFunc("/api/history/status", handleHistoryStatus)
+	apiMux.HandleFunc("/api/search", handleSearch)
+	mux.Handle("/api/", AuthMiddleware(apiMux))
 
 	// Static files
 	webContent, _ := fs.Sub(webFS, "web")
@@ -82,8 +91,10 @@ func main() {
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
 		fmt.Println("\nShutting down...")
-		cancel()
-		client.Disconnect()
+		for _, s := range sessions.list() {
+			s.client.Disconnect()
+			s.store.Close()
+		}
 		os.Exit(0)
 	}()
 
@@ -120,12 +131,18 @@ func openBrowser(url string) {
 func handleChats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
 	limit := 50
 	if l := r.URL.Query().Get("limit"); l != "" {
 		fmt.Sscanf(l, "%d", &limit)
 	}
 
-	chats, err := store.ListChats(db.ListChatsOpts{
+	chats, err := s.store.ListChats(db.ListChatsOpts{
 		Limit:              limit,
 		IncludeLastMessage: true,
 		SortBy:             "last_active",
@@ -141,6 +158,12 @@ func handleChats(w http.ResponseWriter, r *http.Request) {
 func handleMessages(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
 	chatJID := r.URL.Query().Get("chat_jid")
 	if chatJID == "" {
 		http.Error(w, "chat_jid required", http.StatusBadRequest)
@@ -152,7 +175,7 @@ func handleMessages(w http.ResponseWriter, r *http.Request) {
 		fmt.Sscanf(l, "%d", &limit)
 	}
 
-	messages, err := store.ListMessages(db.ListMessagesOpts{
+	messages, err := s.store.ListMessages(db.ListMessagesOpts{
 		ChatJID:        &chatJID,
 		Limit:          limit,
 		IncludeContext: false,
@@ -173,6 +196,12 @@ func handleSend(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
 	var req struct {
 		Recipient string `json:"recipient"`
 		Message   string `json:"message"`
@@ -182,7 +211,7 @@ func handleSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if client == nil || !client.IsConnected() {
+	if !s.client.IsConnected() {
 		json.NewEncoder(w).Encode(map[string]any{
 			"success": false,
 			"error":   "WhatsApp not connected",
@@ -190,7 +219,7 @@ func handleSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	success, msg := client.SendMessage(req.Recipient, req.Message)
+	success, msg := s.client.SendMessage(req.Recipient, req.Message)
 	json.NewEncoder(w).Encode(map[string]any{
 		"success": success,
 		"message": msg,
@@ -200,13 +229,19 @@ func handleSend(w http.ResponseWriter, r *http.Request) {
 func handleContacts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
 	query := r.URL.Query().Get("q")
 	if query == "" {
 		http.Error(w, "q (query) required", http.StatusBadRequest)
 		return
 	}
 
-	contacts, err := store.SearchContacts(query)
+	contacts, err := s.store.SearchContacts(query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -218,8 +253,11 @@ func handleContacts(w http.ResponseWriter, r *http.Request) {
 func handleStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	connected := client != nil && client.IsConnected()
-	json.NewEncoder(w).Encode(map[string]any{
-		"connected": connected,
-	})
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(BridgeState{StateEvent: StateLoggedOut, Reason: "no_session"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.getBridgeState())
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CSCSoftware/wahoo/db"
+	"github.com/CSCSoftware/wahoo/wa"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const maxMediaUpload = 64 << 20 // 64MB, generous enough for voice notes and documents
+
+// Media kinds, used to whitelist the Content-Type handleMedia serves rather
+// than trusting the mimetype stored from the (attacker-controlled) inbound
+// WhatsApp message.
+const (
+	mediaKindImage    = "image"
+	mediaKindVideo    = "video"
+	mediaKindAudio    = "audio"
+	mediaKindDocument = "document"
+	mediaKindSticker  = "sticker"
+)
+
+// handleSendMedia accepts a multipart upload and dispatches it as an image,
+// video, audio, or document message depending on its mimetype, or as a
+// sticker message when the as_sticker form field is "true".
+func handleSendMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaUpload)
+	if err := r.ParseMultipartForm(maxMediaUpload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mimetype := header.Header.Get("Content-Type")
+	if mimetype == "" {
+		mimetype = http.DetectContentType(data)
+	}
+
+	if !s.client.IsConnected() {
+		json.NewEncoder(w).Encode(map[string]any{
+			"success": false,
+			"error":   "WhatsApp not connected",
+		})
+		return
+	}
+
+	success, msg := s.client.SendMedia(wa.SendMediaOpts{
+		Recipient: r.FormValue("recipient"),
+		Data:      data,
+		Mimetype:  mimetype,
+		Filename:  header.Filename,
+		Caption:   r.FormValue("caption"),
+		ReplyToID: r.FormValue("reply_to"),
+		AsSticker: r.FormValue("as_sticker") == "true",
+	})
+	json.NewEncoder(w).Encode(map[string]any{
+		"success": success,
+		"message": msg,
+	})
+}
+
+// handleMedia serves the bytes for a message's attachment, downloading and
+// decrypting them from WhatsApp on first request and caching them to the
+// session's own store-dir/sessions/<jid>/media/ for every subsequent one.
+func handleMedia(w http.ResponseWriter, r *http.Request) {
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	messageID, err := sanitizeMessageID(r.URL.Query().Get("message_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ref, err := s.store.GetMediaRef(messageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	cacheDir := filepath.Join(sessions.dir(s.jid), "media")
+	cachePath := filepath.Join(cacheDir, messageID)
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		data, err = s.client.DownloadMedia(ref)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create media cache dir: %v\n", err)
+		} else if err := os.WriteFile(cachePath, data, 0o600); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to cache media for %s: %v\n", messageID, err)
+		}
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", safeMediaContentType(ref.Kind, ref.Mimetype))
+	if ref.Kind == mediaKindDocument || ref.Kind == mediaKindSticker {
+		filename := ref.Filename
+		if filename == "" {
+			filename = messageID
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sanitizeHeaderValue(filename)))
+	}
+	w.Write(data)
+}
+
+// safeMediaContentType whitelists the Content-Type served for a message's
+// attachment by the kind whatsmeow classified it as, rather than trusting
+// the mimetype string stored from the inbound (sender-controlled) protobuf
+// verbatim - a forged mimetype like "text/html" on an image message would
+// otherwise let an attacker serve arbitrary HTML/JS same-origin with the app.
+// Documents and stickers are always served as a generic download.
+func safeMediaContentType(kind, mimetype string) string {
+	var prefix string
+	switch kind {
+	case mediaKindImage:
+		prefix = "image/"
+	case mediaKindVideo:
+		prefix = "video/"
+	case mediaKindAudio:
+		prefix = "audio/"
+	default:
+		return "application/octet-stream"
+	}
+	// SVG images can carry script, so they get the generic type too.
+	if strings.HasPrefix(mimetype, prefix) && !strings.Contains(strings.ToLower(mimetype), "svg") {
+		return mimetype
+	}
+	return "application/octet-stream"
+}
+
+// sanitizeHeaderValue strips characters that don't belong in a header value
+// (quotes, control characters) before a stored filename is reflected into
+// Content-Disposition.
+func sanitizeHeaderValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '"' || r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// handleMediaPreview returns a message's cached thumbnail and/or waveform
+// without downloading and decrypting the full attachment, so chat/message
+// list views can render previews cheaply.
+func handleMediaPreview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	messageID, err := sanitizeMessageID(r.URL.Query().Get("message_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ref, err := s.store.GetMediaRef(messageID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"mimetype":      ref.Mimetype,
+		"is_voice_note": ref.IsVoiceNote,
+		"thumbnail":     base64.StdEncoding.EncodeToString(ref.ThumbnailJPEG),
+		"waveform":      base64.StdEncoding.EncodeToString(ref.Waveform),
+	})
+}
+
+// sanitizeMessageID rejects anything that isn't a bare filename component,
+// since message_id comes from the query string and is used to build a path
+// under the media cache directory.
+func sanitizeMessageID(id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("message_id required")
+	}
+	if clean := filepath.Base(id); clean != id || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid message_id")
+	}
+	return id, nil
+}
+
+// registerMediaHandlers persists a reference (URL, keys, mimetype, waveform,
+// thumbnail) for every inbound message that carries an attachment, so
+// handleMedia can later fetch it on demand instead of downloading eagerly.
+func registerMediaHandlers(s *session) {
+	s.client.AddEventHandler(func(evt any) {
+		msg, ok := evt.(*events.Message)
+		if !ok || msg.Message == nil {
+			return
+		}
+
+		ref, ok := extractMediaRef(msg.Message)
+		if !ok {
+			return
+		}
+
+		if err := s.store.SaveMediaRef(msg.Info.ID, ref); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save media ref for %s: %v\n", msg.Info.ID, err)
+		}
+	})
+}
+
+func extractMediaRef(m *waE2E.Message) (db.MediaRef, bool) {
+	switch {
+	case m.GetImageMessage() != nil:
+		img := m.GetImageMessage()
+		return db.MediaRef{
+			Kind:          mediaKindImage,
+			Mimetype:      img.GetMimetype(),
+			Caption:       img.GetCaption(),
+			URL:           img.GetURL(),
+			MediaKey:      img.GetMediaKey(),
+			FileSHA256:    img.GetFileSHA256(),
+			FileEncSHA256: img.GetFileEncSHA256(),
+			FileLength:    img.GetFileLength(),
+			ThumbnailJPEG: img.GetJPEGThumbnail(),
+		}, true
+	case m.GetVideoMessage() != nil:
+		vid := m.GetVideoMessage()
+		return db.MediaRef{
+			Kind:          mediaKindVideo,
+			Mimetype:      vid.GetMimetype(),
+			Caption:       vid.GetCaption(),
+			URL:           vid.GetURL(),
+			MediaKey:      vid.GetMediaKey(),
+			FileSHA256:    vid.GetFileSHA256(),
+			FileEncSHA256: vid.GetFileEncSHA256(),
+			FileLength:    vid.GetFileLength(),
+			ThumbnailJPEG: vid.GetJPEGThumbnail(),
+		}, true
+	case m.GetAudioMessage() != nil:
+		aud := m.GetAudioMessage()
+		return db.MediaRef{
+			Kind:          mediaKindAudio,
+			Mimetype:      aud.GetMimetype(),
+			URL:           aud.GetURL(),
+			MediaKey:      aud.GetMediaKey(),
+			FileSHA256:    aud.GetFileSHA256(),
+			FileEncSHA256: aud.GetFileEncSHA256(),
+			FileLength:    aud.GetFileLength(),
+			Waveform:      aud.GetWaveform(),
+			IsVoiceNote:   aud.GetPTT(),
+		}, true
+	case m.GetDocumentMessage() != nil:
+		doc := m.GetDocumentMessage()
+		return db.MediaRef{
+			Kind:          mediaKindDocument,
+			Mimetype:      doc.GetMimetype(),
+			Caption:       doc.GetCaption(),
+			Filename:      strings.TrimSpace(doc.GetFileName()),
+			URL:           doc.GetURL(),
+			MediaKey:      doc.GetMediaKey(),
+			FileSHA256:    doc.GetFileSHA256(),
+			FileEncSHA256: doc.GetFileEncSHA256(),
+			FileLength:    doc.GetFileLength(),
+			ThumbnailJPEG: doc.GetJPEGThumbnail(),
+		}, true
+	case m.GetStickerMessage() != nil:
+		stk := m.GetStickerMessage()
+		return db.MediaRef{
+			Kind:          mediaKindSticker,
+			Mimetype:      stk.GetMimetype(),
+			URL:           stk.GetURL(),
+			MediaKey:      stk.GetMediaKey(),
+			FileSHA256:    stk.GetFileSHA256(),
+			FileEncSHA256: stk.GetFileEncSHA256(),
+			FileLength:    stk.GetFileLength(),
+		}, true
+	default:
+		return db.MediaRef{}, false
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/CSCSoftware/wahoo/db"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// BackfillStatus reports how far along the initial history sync for a
+// session is, for display while /api/search results are still incomplete.
+type BackfillStatus struct {
+	InProgress     bool    `json:"in_progress"`
+	ChatsSynced    int     `json:"chats_synced"`
+	MessagesSynced int     `json:"messages_synced"`
+	Percent        float64 `json:"percent"`
+}
+
+func (s *session) updateBackfill(fn func(*BackfillStatus)) {
+	s.mu.Lock()
+	fn(&s.backfill)
+	s.mu.Unlock()
+}
+
+func (s *session) getBackfill() BackfillStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backfill
+}
+
+// registerHistorySync persists whatsmeow's initial backfill into db.Store
+// and tracks progress for /api/history/status.
+func registerHistorySync(s *session) {
+	s.client.AddEventHandler(func(evt any) {
+		hs, ok := evt.(*events.HistorySync)
+		if !ok {
+			return
+		}
+
+		s.updateBackfill(func(b *BackfillStatus) { b.InProgress = true })
+
+		chatsSynced, messagesSynced, err := s.store.SaveHistorySync(hs.Data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to persist history sync for %s: %v\n", s.jid, err)
+			return
+		}
+
+		s.updateBackfill(func(b *BackfillStatus) {
+			b.ChatsSynced += chatsSynced
+			b.MessagesSynced += messagesSynced
+			if p := hs.Data.GetProgress(); p > 0 {
+				b.Percent = float64(p)
+			}
+			if hs.Data.GetProgress() >= 100 {
+				b.InProgress = false
+			}
+		})
+	})
+}
+
+// handleHistoryStatus reports how the initial backfill for a session is progressing.
+func handleHistoryStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.getBackfill())
+}
+
+// handleSearch runs a full-text search over message bodies, sender push
+// names, and chat titles, optionally scoped to a chat and time range.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "q (query) required", http.StatusBadRequest)
+		return
+	}
+
+	opts := db.SearchMessagesOpts{
+		Query:  query,
+		Limit:  20,
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+
+	if v := r.URL.Query().Get("chat_jid"); v != "" {
+		opts.ChatJID = &v
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "from must be RFC3339", http.StatusBadRequest)
+			return
+		}
+		opts.From = &t
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "to must be RFC3339", http.StatusBadRequest)
+			return
+		}
+		opts.To = &t
+	}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &opts.Limit)
+	}
+
+	results, err := s.store.SearchMessages(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(results)
+}
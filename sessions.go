@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CSCSoftware/wahoo/db"
+	"github.com/CSCSoftware/wahoo/wa"
+)
+
+// loginState mirrors the stages whatsmeow walks a device through when pairing.
+type loginState string
+
+const (
+	loginDisconnected loginState = "disconnected"
+	loginQR           loginState = "qr"
+	loginPairSuccess  loginState = "pair-success"
+	loginConnected    loginState = "connected"
+	loginLoggedOut    loginState = "logged-out"
+)
+
+// session bundles one WhatsApp account's store, client, and pairing state.
+type session struct {
+	mu           sync.RWMutex
+	jid          string
+	store        *db.Store
+	client       *wa.Client
+	state        loginState
+	bridgeState  BridgeState
+	stateSubs    map[chan BridgeState]struct{}
+	backfill     BackfillStatus
+	reconnecting atomic.Bool // guards against overlapping reconnectWithBackoff loops
+}
+
+func (s *session) setState(st loginState) {
+	s.mu.Lock()
+	s.state = st
+	s.mu.Unlock()
+}
+
+func (s *session) getState() loginState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// setStateUnlessLoggedOut applies a transition unless the session has
+// already been logged out, so a disconnect event racing with teardown can't
+// resurrect a loginState that reconnectWithBackoff/handleLogout rely on to
+// stop retrying.
+func (s *session) setStateUnlessLoggedOut(st loginState) {
+	s.mu.Lock()
+	if s.state != loginLoggedOut {
+		s.state = st
+	}
+	s.mu.Unlock()
+}
+
+// sessionManager tracks every paired device under storeDir, keyed by JID, so
+// one wahoo-ui instance can drive several WhatsApp accounts at once.
+type sessionManager struct {
+	mu       sync.RWMutex
+	storeDir string
+	sessions map[string]*session
+	order    []string // JIDs in the order they were loaded; order[0] is the default
+}
+
+func newSessionManager(storeDir string) (*sessionManager, error) {
+	m := &sessionManager{
+		storeDir: storeDir,
+		sessions: make(map[string]*session),
+	}
+
+	entries, err := os.ReadDir(filepath.Join(storeDir, "sessions"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("reading sessions dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		jid := entry.Name()
+		if strings.HasPrefix(jid, "pending-") {
+			// Leftover from a pairing attempt that never finished (e.g. the
+			// process was killed mid-scan); not a real paired session.
+			continue
+		}
+		if err := m.load(jid); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load session %s: %v\n", jid, err)
+			continue
+		}
+	}
+
+	return m, nil
+}
+
+func (m *sessionManager) dir(jid string) string {
+	return filepath.Join(m.storeDir, "sessions", jid)
+}
+
+// load opens the store and client for an already-paired JID and connects it
+// in the background.
+func (m *sessionManager) load(jid string) error {
+	dir := m.dir(jid)
+
+	store, err := db.NewStore(dir)
+	if err != nil {
+		return fmt.Errorf("opening store: %w", err)
+	}
+
+	client, err := wa.NewClient(store, dir)
+	if err != nil {
+		store.Close()
+		return fmt.Errorf("creating client: %w", err)
+	}
+
+	s := &session{jid: jid, store: store, client: client, state: loginDisconnected}
+	registerMediaHandlers(s)
+	registerStateHandlers(s)
+	registerHistorySync(s)
+
+	m.mu.Lock()
+	m.sessions[jid] = s
+	m.order = append(m.order, jid)
+	m.mu.Unlock()
+
+	go runSession(s)
+
+	return nil
+}
+
+// get returns the session for jid, or the default (first loaded) session
+// when jid is empty.
+func (m *sessionManager) get(jid string) (*session, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if jid == "" {
+		if len(m.order) == 0 {
+			return nil, false
+		}
+		jid = m.order[0]
+	}
+
+	s, ok := m.sessions[jid]
+	return s, ok
+}
+
+func (m *sessionManager) list() []*session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]*session, 0, len(m.order))
+	for _, jid := range m.order {
+		out = append(out, m.sessions[jid])
+	}
+	return out
+}
+
+// register adopts a freshly-paired session (JID becomes known only after
+// GetQRChannel reports "success") under the manager.
+func (m *sessionManager) register(s *session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.sessions[s.jid]; !exists {
+		m.order = append(m.order, s.jid)
+	}
+	m.sessions[s.jid] = s
+}
+
+// remove disconnects a session, closes its store, and deletes it from disk.
+// It does not log the device out of WhatsApp; handleLogout calls
+// client.Logout before removing the session.
+func (m *sessionManager) remove(jid string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[jid]
+	if ok {
+		delete(m.sessions, jid)
+		for i, id := range m.order {
+			if id == jid {
+				m.order = append(m.order[:i], m.order[i+1:]...)
+				break
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown session %q", jid)
+	}
+
+	// Set before tearing down so any in-flight reconnectWithBackoff loop
+	// observes loginLoggedOut and stops instead of calling Connect() again
+	// on a closed store/deleted directory.
+	s.setState(loginLoggedOut)
+	s.client.Disconnect()
+	s.store.Close()
+	return os.RemoveAll(m.dir(jid))
+}
+
+// sessionFromRequest resolves the ?session=<jid> selector, falling back to
+// the default (first loaded) session when it's omitted.
+func sessionFromRequest(r *http.Request) (*session, error) {
+	jid := r.URL.Query().Get("session")
+	s, ok := sessions.get(jid)
+	if !ok {
+		if jid == "" {
+			return nil, fmt.Errorf("no WhatsApp sessions paired yet")
+		}
+		return nil, fmt.Errorf("unknown session %q", jid)
+	}
+	return s, nil
+}
+
+// runSession connects a loaded session. Apart from marking it disconnected
+// when this initial Connect fails, loginState is driven by the
+// *events.Connected/Disconnected/LoggedOut handlers in registerStateHandlers,
+// so it can't drift from BridgeState during reconnects.
+func runSession(s *session) {
+	if err := s.client.Connect(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Session %s connection error: %v\n", s.jid, err)
+		s.setState(loginDisconnected)
+	}
+}
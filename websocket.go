@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = (wsPongWait * 9) / 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsFrame is the envelope every event is forwarded to the browser as.
+type wsFrame struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// handleEvents upgrades the connection to a WebSocket and streams whatsmeow
+// events to the browser as they arrive, optionally filtered to a single chat.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	s, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	chatFilter := r.URL.Query().Get("chat_jid")
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("events: upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	send := make(chan []byte, 32)
+
+	handlerID := s.client.AddEventHandler(func(evt any) {
+		frame, ok := encodeEvent(evt, chatFilter)
+		if !ok {
+			return
+		}
+		select {
+		case send <- frame:
+		default:
+			// Slow consumer; drop rather than block the event dispatcher.
+		}
+	})
+	defer s.client.RemoveEventHandler(handlerID)
+
+	stateCh, unsubscribe := s.subscribeBridgeState()
+	defer unsubscribe()
+	go func() {
+		for bs := range stateCh {
+			frame, err := json.Marshal(wsFrame{Type: "bridge_state", Data: bs})
+			if err != nil {
+				continue
+			}
+			select {
+			case send <- frame:
+			default:
+			}
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case frame := <-send:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// encodeEvent converts a whatsmeow event into a JSON frame, or reports ok=false
+// for event types the WebSocket stream doesn't forward (or that chatFilter excludes).
+func encodeEvent(evt any, chatFilter string) (frame []byte, ok bool) {
+	var typ string
+	var data any
+
+	switch e := evt.(type) {
+	case *events.Message:
+		if chatFilter != "" && e.Info.Chat.String() != chatFilter {
+			return nil, false
+		}
+		typ, data = "message", e
+	case *events.Receipt:
+		if chatFilter != "" && e.Chat.String() != chatFilter {
+			return nil, false
+		}
+		typ, data = "receipt", e
+	case *events.Presence:
+		if chatFilter != "" && e.From.String() != chatFilter {
+			return nil, false
+		}
+		typ, data = "presence", e
+	case *events.ChatPresence:
+		if chatFilter != "" && e.Chat.String() != chatFilter {
+			return nil, false
+		}
+		typ, data = "chat_presence", e
+	case *events.HistorySync:
+		typ, data = "history_sync", e
+	default:
+		return nil, false
+	}
+
+	b, err := json.Marshal(wsFrame{Type: typ, Data: data})
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}